@@ -1,6 +1,7 @@
 package backends
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strconv"
@@ -9,11 +10,17 @@ import (
 
 	"github.com/flashmob/go-guerrilla/mail"
 	"github.com/flashmob/go-guerrilla/response"
+	"golang.org/x/sync/errgroup"
 	"strings"
 )
 
 var ErrProcessorNotFound error
 
+// ErrShutdownTimedOut is returned by Shutdown when the workers do not drain
+// the conveyor within shutdownTimeout, eg. because a decorator is stuck on a
+// blocking remote call. The caller can use this to decide whether to force-exit.
+var ErrShutdownTimedOut = errors.New("backend shutdown timed out waiting for workers")
+
 // A backend gateway is a proxy that implements the Backend interface.
 // It is used to start multiple goroutine workers for saving mail, and then distribute email saving to the workers
 // via a channel. Shutting down via Shutdown() will stop all workers.
@@ -26,6 +33,30 @@ type BackendGateway struct {
 	wg sync.WaitGroup
 	w  *Worker
 
+	// ctx is the gateway-scoped context, cancelled by Shutdown so that
+	// workers blocked inside a decorator (eg. a slow remote store) unwind
+	// instead of waiting out the full processTimeout.
+	ctx       context.Context
+	ctxCancel context.CancelFunc
+
+	// statuses holds one WorkerStatus per worker, indexed like lines in
+	// Initialize, for the Stats()/Dump() introspection API
+	statuses []*WorkerStatus
+
+	// Router picks which named processor stack an envelope is dispatched
+	// to. Set by Initialize from gwConfig.Routes, or assign a custom
+	// Router before calling Initialize to use your own routing logic.
+	Router Router
+	// routerIsDefault tracks whether Router was built by Initialize itself
+	// (and so should be rebuilt from gwConfig.Routes on every call, to pick
+	// up a reload's changed routes) as opposed to installed by the caller
+	// before the first Initialize, which is left untouched
+	routerIsDefault bool
+
+	// flushing is set by Flush to reject new envelopes while the existing
+	// conveyor contents drain, without tearing the gateway down
+	flushing bool
+
 	// controls access to state
 	sync.Mutex
 	State    backendState
@@ -34,12 +65,29 @@ type BackendGateway struct {
 }
 
 type GatewayConfig struct {
-	WorkersSize    int    `json:"save_workers_size,omitempty"`
-	ProcessorStack string `json:"process_stack,omitempty"`
+	WorkersSize int `json:"save_workers_size,omitempty"`
+	// ProcessorStacks maps a stack name to a pipe-separated decorator
+	// line, eg. {"default": "Header|Hasher|GuerrillaDbRedis", "relay":
+	// "Header|MimeAnalyzer|LMTP"}. A worker builds one Processor per name.
+	ProcessorStacks map[string]string `json:"process_stacks,omitempty"`
+	// Routes is evaluated in order against each envelope; the first
+	// matching rule's Stack is used, falling back to "default" when none
+	// match or when Routes is empty.
+	Routes []RouteRule `json:"routes,omitempty"`
+	// MaxRestarts is how many times a worker may be restarted within
+	// RestartWindowSeconds after a panic or fatal error; once exceeded the
+	// worker is left dead and the pool shrinks by one. Defaults to 3.
+	MaxRestarts int `json:"max_restarts,omitempty"`
+	// RestartWindowSeconds is the sliding window MaxRestarts is counted
+	// over. Defaults to 60.
+	RestartWindowSeconds int `json:"restart_window_seconds,omitempty"`
 }
 
 // workerMsg is what get placed on the BackendGateway.saveMailChan channel
 type workerMsg struct {
+	// ctx is carried through to workDispatcher and on into the processor
+	// stack so a decorator can bail out early once it's cancelled
+	ctx context.Context
 	// The email data
 	e *mail.Envelope
 	// savedNotify is used to notify that the save operation completed
@@ -56,6 +104,10 @@ const (
 
 	processTimeout   = time.Second * 30
 	defaultProcessor = "Debugger"
+
+	// shutdownTimeout bounds how long Shutdown waits for workers to drain
+	// the conveyor after the gateway context has been cancelled
+	shutdownTimeout = time.Second * 10
 )
 
 type backendState int
@@ -64,16 +116,35 @@ func (s backendState) String() string {
 	return strconv.Itoa(int(s))
 }
 
-// Process distributes an envelope to one of the backend workers
+// Process distributes an envelope to one of the backend workers, timing out
+// after processTimeout. It is a thin wrapper around ProcessCtx for callers
+// that don't need their own cancellation.
 func (gw *BackendGateway) Process(e *mail.Envelope) Result {
+	ctx, cancel := context.WithTimeout(gw.context(), processTimeout)
+	defer cancel()
+	return gw.ProcessCtx(ctx, e)
+}
+
+// ProcessCtx distributes an envelope to one of the backend workers. Unlike
+// Process, the caller controls cancellation directly via ctx: when ctx is
+// done (client disconnect, server shutdown, per-session deadline) the call
+// returns FailBackendTimeout without waiting for a worker to pick it up.
+func (gw *BackendGateway) ProcessCtx(ctx context.Context, e *mail.Envelope) Result {
 	if gw.State != BackendStateRunning {
 		return NewResult(response.Canned.FailBackendNotRunning + gw.State.String())
 	}
+	if gw.isFlushing() {
+		return NewResult(response.Canned.FailBackendNotRunning + "flushing")
+	}
 	// place on the channel so that one of the save mail workers can pick it up
 	savedNotify := make(chan *notifyMsg)
-	gw.conveyor <- &workerMsg{e, savedNotify, TaskSaveMail}
-	// wait for the save to complete
-	// or timeout
+	select {
+	case gw.conveyor <- &workerMsg{ctx, e, savedNotify, TaskSaveMail}:
+	case <-ctx.Done():
+		Log().Infof("Process cancelled before a worker picked it up: %s", ctx.Err())
+		return NewResult(response.Canned.FailBackendTimeout)
+	}
+	// wait for the save to complete, for the context to be cancelled, or to time out
 	select {
 	case status := <-savedNotify:
 		if status.err != nil {
@@ -81,24 +152,38 @@ func (gw *BackendGateway) Process(e *mail.Envelope) Result {
 		}
 		return NewResult(response.Canned.SuccessMessageQueued + status.queuedID)
 
-	case <-time.After(processTimeout):
-		Log().Infof("Backend has timed out")
+	case <-ctx.Done():
+		Log().Infof("Process cancelled: %s", ctx.Err())
 		return NewResult(response.Canned.FailBackendTimeout)
 	}
-
 }
 
-// ValidateRcpt asks one of the workers to validate the recipient
+// ValidateRcpt asks one of the workers to validate the recipient, timing out
+// after one second. It is a thin wrapper around ValidateRcptCtx.
 // Only the last recipient appended to e.RcptTo will be validated.
 func (gw *BackendGateway) ValidateRcpt(e *mail.Envelope) RcptError {
+	ctx, cancel := context.WithTimeout(gw.context(), time.Second)
+	defer cancel()
+	return gw.ValidateRcptCtx(ctx, e)
+}
+
+// ValidateRcptCtx is the context-aware variant of ValidateRcpt: it gives up
+// and returns StorageTimeout as soon as ctx is done, instead of always
+// waiting out a fixed timeout.
+// Only the last recipient appended to e.RcptTo will be validated.
+func (gw *BackendGateway) ValidateRcptCtx(ctx context.Context, e *mail.Envelope) RcptError {
 	if gw.State != BackendStateRunning {
 		return StorageNotAvailable
 	}
 	// place on the channel so that one of the save mail workers can pick it up
 	notify := make(chan *notifyMsg)
-	gw.conveyor <- &workerMsg{e, notify, TaskValidateRcpt}
-	// wait for the validation to complete
-	// or timeout
+	select {
+	case gw.conveyor <- &workerMsg{ctx, e, notify, TaskValidateRcpt}:
+	case <-ctx.Done():
+		Log().Infof("ValidateRcpt cancelled before a worker picked it up: %s", ctx.Err())
+		return StorageTimeout
+	}
+	// wait for the validation to complete, for the context to be cancelled, or to time out
 	select {
 	case status := <-notify:
 		if status.err != nil {
@@ -106,20 +191,91 @@ func (gw *BackendGateway) ValidateRcpt(e *mail.Envelope) RcptError {
 		}
 		return nil
 
-	case <-time.After(time.Second):
-		Log().Infof("Backend has timed out")
+	case <-ctx.Done():
+		Log().Infof("ValidateRcpt cancelled: %s", ctx.Err())
 		return StorageTimeout
 	}
 }
 
-// Shutdown shuts down the backend and leaves it in BackendStateShuttered state
+// context returns the gateway-scoped context, falling back to
+// context.Background() if the gateway has not been initialized yet.
+func (gw *BackendGateway) context() context.Context {
+	if gw.ctx != nil {
+		return gw.ctx
+	}
+	return context.Background()
+}
+
+// isFlushing reports whether the gateway is currently draining for Flush.
+func (gw *BackendGateway) isFlushing() bool {
+	gw.Lock()
+	defer gw.Unlock()
+	return gw.flushing
+}
+
+// Depth returns the number of envelopes currently queued on the conveyor,
+// waiting for a worker to pick them up.
+func (gw *BackendGateway) Depth() int {
+	gw.Lock()
+	defer gw.Unlock()
+	return len(gw.conveyor)
+}
+
+// Flush stops the gateway from accepting new envelopes and blocks until the
+// conveyor has fully drained, without shutting the gateway down - unlike
+// Shutdown, workers stay up and Process can be resumed with Unflush. This
+// lets an operator drain a node before a deploy without dropping in-flight
+// SMTP transactions. Returns ctx.Err() if ctx is done before the conveyor empties.
+func (gw *BackendGateway) Flush(ctx context.Context) error {
+	gw.Lock()
+	gw.flushing = true
+	gw.Unlock()
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if gw.Depth() == 0 {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Unflush resumes accepting new envelopes after a Flush.
+func (gw *BackendGateway) Unflush() {
+	gw.Lock()
+	gw.flushing = false
+	gw.Unlock()
+}
+
+// Shutdown shuts down the backend and leaves it in BackendStateShuttered state.
+// It first cancels the gateway's context so that workers blocked inside a
+// decorator (eg. a slow LMTP relay or remote store) unwind, then closes the
+// conveyor, then waits for the workers to stop with a bounded deadline. If
+// the workers do not drain in time, ErrShutdownTimedOut is returned so the
+// caller can decide whether to force-exit.
 func (gw *BackendGateway) Shutdown() error {
 	gw.Lock()
 	defer gw.Unlock()
 	if gw.State != BackendStateShuttered {
+		if gw.ctxCancel != nil {
+			gw.ctxCancel()
+		}
 		close(gw.conveyor) // workers will stop
-		// wait for workers to stop
-		gw.wg.Wait()
+		// wait for workers to stop, but don't wait forever
+		done := make(chan struct{})
+		go func() {
+			gw.wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(shutdownTimeout):
+			return ErrShutdownTimedOut
+		}
 		Svc.shutdown()
 		gw.State = BackendStateShuttered
 	}
@@ -135,18 +291,18 @@ func (gw *BackendGateway) Reinitialize() error {
 	if err != nil {
 		return fmt.Errorf("error while initializing the backend: %s", err)
 	}
-
-	gw.State = BackendStateRunning
 	return err
 }
 
-// newProcessorLine creates a new call-stack of decorators and returns as a single Processor
-// Decorators are functions of Decorator type, source files prefixed with p_*
-// Each decorator does a specific task during the processing stage.
-// This function uses the config value process_stack to figure out which Decorator to use
-func (gw *BackendGateway) newProcessorStack() (Processor, error) {
+// buildStack creates a new call-stack of decorators from a pipe-separated
+// cfg line (eg. "Header|Hasher|GuerrillaDbRedis") and returns it as a single
+// Processor. Decorators are functions of Decorator type, source files
+// prefixed with p_*. Each decorator does a specific task during the
+// processing stage, and is wrapped in a tracingProcessor so status reports
+// which decorator a worker is currently inside (see worker_status.go).
+func (gw *BackendGateway) buildStack(cfg string, status *WorkerStatus) (Processor, error) {
 	var decorators []Decorator
-	cfg := strings.ToLower(strings.TrimSpace(gw.gwConfig.ProcessorStack))
+	cfg = strings.ToLower(strings.TrimSpace(cfg))
 	if len(cfg) == 0 {
 		cfg = strings.ToLower(defaultProcessor)
 	}
@@ -154,7 +310,7 @@ func (gw *BackendGateway) newProcessorStack() (Processor, error) {
 	for i := range line {
 		name := line[len(line)-1-i] // reverse order, since decorators are stacked
 		if makeFunc, ok := processors[name]; ok {
-			decorators = append(decorators, makeFunc())
+			decorators = append(decorators, traceDecorator(name, status, makeFunc()))
 		} else {
 			ErrProcessorNotFound = errors.New(fmt.Sprintf("processor [%s] not found", name))
 			return nil, ErrProcessorNotFound
@@ -165,6 +321,35 @@ func (gw *BackendGateway) newProcessorStack() (Processor, error) {
 	return p, nil
 }
 
+// newProcessorStacks builds one Processor per name in gwConfig.ProcessorStacks
+// for a single worker, identified by status. When ProcessorStacks is empty
+// (no process_stacks configured) it builds a single "default" stack from
+// defaultProcessor, matching the pre-routing behaviour.
+func (gw *BackendGateway) newProcessorStacks(status *WorkerStatus) (map[string]Processor, error) {
+	cfgs := gw.gwConfig.ProcessorStacks
+	if len(cfgs) == 0 {
+		cfgs = map[string]string{"default": defaultProcessor}
+	}
+	stacks := make(map[string]Processor, len(cfgs))
+	for name, line := range cfgs {
+		p, err := gw.buildStack(line, status)
+		if err != nil {
+			return nil, err
+		}
+		stacks[name] = p
+	}
+	return stacks, nil
+}
+
+// traceDecorator wraps d so that, once applied, the resulting Processor
+// updates status to record name as the currently-executing decorator before
+// delegating to the rest of the stack.
+func traceDecorator(name string, status *WorkerStatus, d Decorator) Decorator {
+	return func(next Processor) Processor {
+		return tracingProcessor{name: name, status: status, next: d(next)}
+	}
+}
+
 // loadConfig loads the config for the GatewayConfig
 func (gw *BackendGateway) loadConfig(cfg BackendConfig) error {
 	configType := BaseConfig(&GatewayConfig{})
@@ -179,7 +364,11 @@ func (gw *BackendGateway) loadConfig(cfg BackendConfig) error {
 	return nil
 }
 
-// Initialize builds the workers and starts each worker in a goroutine
+// Initialize builds the workers and starts each worker in a goroutine.
+// Building each worker's processor stacks and running Svc.initialize (which
+// opens the decorators' own connections - Redis, SQL, S3, ...) are
+// independent, so they run concurrently via errgroup; a decorator that's
+// slow to connect no longer serializes with workersSize other stack builds.
 func (gw *BackendGateway) Initialize(cfg BackendConfig) error {
 	gw.Lock()
 	defer gw.Unlock()
@@ -190,33 +379,162 @@ func (gw *BackendGateway) Initialize(cfg BackendConfig) error {
 			gw.State = BackendStateError
 			return errors.New("Must have at least 1 worker")
 		}
-		var lines []Processor
+		lines := make([]map[string]Processor, workersSize)
+		statuses := make([]*WorkerStatus, workersSize)
+		g, gctx := errgroup.WithContext(context.Background())
 		for i := 0; i < workersSize; i++ {
-			p, err := gw.newProcessorStack()
-			if err != nil {
-				return err
-			}
-			lines = append(lines, p)
+			workerId := i
+			statuses[workerId] = newWorkerStatus(workerId + 1)
+			g.Go(func() error {
+				stacks, buildErr := gw.newProcessorStacks(statuses[workerId])
+				if buildErr != nil {
+					return buildErr
+				}
+				lines[workerId] = stacks
+				return nil
+			})
 		}
-		// initialize processors
-		if err := Svc.initialize(cfg); err != nil {
+		g.Go(func() error {
+			return Svc.initialize(cfg)
+		})
+		if err := g.Wait(); err != nil {
+			gw.State = BackendStateError
 			return err
 		}
+		_ = gctx // gctx is cancelled on first error; nothing else observes it here
+		gw.statuses = statuses
+		// rebuilt on every Initialize (not just the first) so a
+		// reload-backend picks up changed Routes; a caller that installed
+		// its own Router before the very first Initialize is left alone
+		if gw.Router == nil || gw.routerIsDefault {
+			router, routerErr := newRuleRouter(gw.gwConfig.Routes, "default")
+			if routerErr != nil {
+				gw.State = BackendStateError
+				return routerErr
+			}
+			gw.Router = router
+			gw.routerIsDefault = true
+		}
 		gw.conveyor = make(chan *workerMsg, workersSize)
-		// start our workers
+		gw.ctx, gw.ctxCancel = context.WithCancel(context.Background())
+		// start our workers, each supervised so a panic or fatal error
+		// gets the worker restarted instead of silently shrinking the pool
 		gw.wg.Add(workersSize)
 		for i := 0; i < workersSize; i++ {
-			go func(workerId int) {
-				gw.w.workDispatcher(gw.conveyor, lines[workerId], workerId+1)
-				gw.wg.Done()
-			}(i)
+			go gw.superviseWorker(i, lines[i], statuses[i])
 		}
+		gw.config = cfg
+		gw.State = BackendStateRunning
 	} else {
 		gw.State = BackendStateError
 	}
 	return err
 }
 
+// restartPolicy returns the configured MaxRestarts/RestartWindow, applying
+// the package defaults when unset.
+func (gw *BackendGateway) restartPolicy() (int, time.Duration) {
+	maxRestarts := gw.gwConfig.MaxRestarts
+	if maxRestarts == 0 {
+		maxRestarts = 3
+	}
+	window := time.Duration(gw.gwConfig.RestartWindowSeconds) * time.Second
+	if window == 0 {
+		window = time.Minute
+	}
+	return maxRestarts, window
+}
+
+// superviseWorker runs the worker's dispatch loop, restarting it up to
+// MaxRestarts times within RestartWindow if it panics or returns an error,
+// so a single bad decorator (eg. a lost DB handle) doesn't silently shrink
+// the pool. It returns for good - letting wg.Done fire - once workDispatcher
+// returns nil (the conveyor was closed by Shutdown), once gw.ctx has been
+// cancelled (an ordinary graceful Shutdown, not a crash), or once restarts
+// are exhausted.
+func (gw *BackendGateway) superviseWorker(workerId int, stacks map[string]Processor, status *WorkerStatus) {
+	defer gw.wg.Done()
+	maxRestarts, window := gw.restartPolicy()
+	var restarts []time.Time
+	for {
+		err := gw.runWorker(workerId, stacks, status)
+		if err == nil {
+			return
+		}
+		if gw.ctx.Err() != nil {
+			// Shutdown cancelled the gateway context, which is exactly
+			// what makes workDispatcher return ctx.Err() here - this is
+			// graceful shutdown racing the supervisor, not a crash, so
+			// don't restart and don't log it as one.
+			return
+		}
+		now := time.Now()
+		restarts = pruneRestarts(restarts, now, window)
+		restarts = append(restarts, now)
+		if len(restarts) > maxRestarts {
+			Log().Errorf("worker %d exceeded %d restarts within %s, giving up: %s", workerId+1, maxRestarts, window, err)
+			return
+		}
+		Log().Errorf("worker %d crashed, restarting (%d/%d): %s", workerId+1, len(restarts), maxRestarts, err)
+	}
+}
+
+// pruneRestarts returns restarts with every entry older than window (as of
+// now) dropped, preserving order. Split out of superviseWorker so the
+// sliding-window dedupe math can be unit tested without spinning up workers.
+func pruneRestarts(restarts []time.Time, now time.Time, window time.Duration) []time.Time {
+	cutoff := now.Add(-window)
+	kept := restarts[:0]
+	for _, t := range restarts {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	return kept
+}
+
+// runWorker invokes workDispatcher once, recovering a panic into an error so
+// superviseWorker can decide whether to restart it.
+func (gw *BackendGateway) runWorker(workerId int, stacks map[string]Processor, status *WorkerStatus) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("worker %d panicked: %v", workerId+1, r)
+		}
+	}()
+	return gw.w.workDispatcher(gw.ctx, gw.conveyor, stacks, gw.Router, status)
+}
+
+// HealthCheck fails fast if any single worker has been stuck inside the same
+// decorator for longer than processTimeout (a wedged worker, per Stats()),
+// if the conveyor is saturated (channel full), or if no worker picks up a
+// TaskHealthCheck probe before ctx is done. That last probe only proves one
+// idle worker is still pulling off the conveyor - the per-worker Stats()
+// scan is what actually catches the other N-1 workers if they're wedged, so
+// callers get a real readiness signal instead of just one that checking the
+// SMTP port is open would give them.
+func (gw *BackendGateway) HealthCheck(ctx context.Context) error {
+	if gw.State != BackendStateRunning {
+		return fmt.Errorf("backend not running: %s", gw.State.String())
+	}
+	for _, s := range gw.Stats() {
+		if s.ActiveDecorator() != "" && s.Elapsed() > processTimeout {
+			return fmt.Errorf("worker %d has been stuck in %s for %s", s.WorkerID, s.ActiveDecorator(), s.Elapsed())
+		}
+	}
+	notify := make(chan *notifyMsg, 1)
+	select {
+	case gw.conveyor <- &workerMsg{ctx, &mail.Envelope{}, notify, TaskHealthCheck}:
+	default:
+		return errors.New("backend queue is saturated")
+	}
+	select {
+	case status := <-notify:
+		return status.err
+	case <-ctx.Done():
+		return fmt.Errorf("health check timed out: %s", ctx.Err())
+	}
+}
+
 // workersSize gets the number of workers to use for saving email by reading the save_workers_size config value
 // Returns 1 if no config value was set
 func (gw *BackendGateway) workersSize() int {
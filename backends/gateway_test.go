@@ -0,0 +1,113 @@
+package backends
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPruneRestarts(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	window := time.Minute
+
+	cases := []struct {
+		name     string
+		restarts []time.Time
+		want     int
+	}{
+		{
+			name:     "empty",
+			restarts: nil,
+			want:     0,
+		},
+		{
+			name:     "all within window are kept",
+			restarts: []time.Time{now.Add(-10 * time.Second), now.Add(-30 * time.Second)},
+			want:     2,
+		},
+		{
+			name:     "entries older than window are dropped",
+			restarts: []time.Time{now.Add(-2 * time.Minute), now.Add(-10 * time.Second)},
+			want:     1,
+		},
+		{
+			name:     "entry exactly at the cutoff is dropped (not After)",
+			restarts: []time.Time{now.Add(-window)},
+			want:     0,
+		},
+		{
+			name:     "all older than window are dropped",
+			restarts: []time.Time{now.Add(-2 * time.Minute), now.Add(-3 * time.Minute)},
+			want:     0,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := pruneRestarts(c.restarts, now, window)
+			if len(got) != c.want {
+				t.Fatalf("pruneRestarts() returned %d entries, want %d", len(got), c.want)
+			}
+		})
+	}
+}
+
+func TestFlushReturnsOnceConveyorDrains(t *testing.T) {
+	gw := &BackendGateway{conveyor: make(chan *workerMsg, 2)}
+	gw.conveyor <- &workerMsg{}
+	gw.conveyor <- &workerMsg{}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- gw.Flush(context.Background())
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("Flush returned before the conveyor drained")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	<-gw.conveyor
+	<-gw.conveyor
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Flush() error = %v, want nil", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Flush did not return after the conveyor drained")
+	}
+
+	if !gw.isFlushing() {
+		t.Fatal("expected gw.flushing to remain true until Unflush is called")
+	}
+}
+
+func TestHealthCheckFailsWhenWorkerIsStuck(t *testing.T) {
+	status := newWorkerStatus(1)
+	status.enter("SlowDecorator")
+	status.stageStarted = time.Now().Add(-2 * processTimeout)
+
+	gw := &BackendGateway{
+		State:    BackendStateRunning,
+		statuses: []*WorkerStatus{status},
+		conveyor: make(chan *workerMsg, 1),
+	}
+
+	if err := gw.HealthCheck(context.Background()); err == nil {
+		t.Fatal("expected HealthCheck to fail for a worker stuck past processTimeout, got nil")
+	}
+}
+
+func TestFlushReturnsCtxErrOnTimeout(t *testing.T) {
+	gw := &BackendGateway{conveyor: make(chan *workerMsg, 1)}
+	gw.conveyor <- &workerMsg{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if err := gw.Flush(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Flush() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
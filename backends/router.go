@@ -0,0 +1,142 @@
+package backends
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/flashmob/go-guerrilla/mail"
+)
+
+// Router selects which named processor stack (a key of
+// GatewayConfig.ProcessorStacks) an envelope should be dispatched to.
+// Users who need routing logic beyond the declarative RouteRule set can
+// implement their own Router and assign it to BackendGateway.Router before
+// calling Initialize.
+type Router interface {
+	// Route returns the processor stack name to use for e. An empty
+	// return value (or a name with no matching stack) falls back to the
+	// "default" stack.
+	Route(e *mail.Envelope) string
+}
+
+// RouteRule is one line of a declarative routing table: when Field
+// (evaluated against the envelope) compares true to Value using Operator,
+// the envelope is routed to Stack. Rules are evaluated in order and the
+// first match wins.
+type RouteRule struct {
+	// Field selects what to compare: "rcpt_domain", "tls", "size", or
+	// "header:X-Name" to read an arbitrary header set by an earlier
+	// decorator
+	Field string `json:"field"`
+	// Operator is "eq", "contains", "gt" or "lt" ("gt"/"lt" are only
+	// meaningful for the numeric "size" field)
+	Operator string `json:"operator"`
+	Value    string `json:"value"`
+	Stack    string `json:"stack"`
+}
+
+// routeFields is the set of Field values extractRouteField understands,
+// besides the "header:" prefix which accepts any header name.
+var routeFields = map[string]bool{
+	"rcpt_domain": true,
+	"tls":         true,
+	"size":        true,
+}
+
+// routeOperators is the set of Operator values RouteRule.matches understands.
+var routeOperators = map[string]bool{
+	"eq":       true,
+	"contains": true,
+	"gt":       true,
+	"lt":       true,
+}
+
+// validate checks that r names a Field and Operator this package actually
+// implements and has a target Stack, so a typo in config (eg. "sizee") fails
+// loudly at Initialize instead of silently never matching.
+func (r RouteRule) validate() error {
+	if !routeFields[r.Field] && !strings.HasPrefix(r.Field, "header:") {
+		return fmt.Errorf("route rule has unknown field %q", r.Field)
+	}
+	if !routeOperators[r.Operator] {
+		return fmt.Errorf("route rule has unknown operator %q", r.Operator)
+	}
+	if r.Stack == "" {
+		return fmt.Errorf("route rule for field %q is missing a target stack", r.Field)
+	}
+	return nil
+}
+
+// ruleRouter is the built-in Router compiled once from a []RouteRule at
+// Initialize time.
+type ruleRouter struct {
+	rules        []RouteRule
+	defaultStack string
+}
+
+// newRuleRouter compiles rules into a Router that falls back to
+// defaultStack when no rule matches. Returns an error if any rule names a
+// field or operator this package doesn't implement, rather than silently
+// building a router that can never match that rule.
+func newRuleRouter(rules []RouteRule, defaultStack string) (*ruleRouter, error) {
+	for _, rule := range rules {
+		if err := rule.validate(); err != nil {
+			return nil, err
+		}
+	}
+	return &ruleRouter{rules: rules, defaultStack: defaultStack}, nil
+}
+
+func (r *ruleRouter) Route(e *mail.Envelope) string {
+	for _, rule := range r.rules {
+		if rule.matches(e) {
+			return rule.Stack
+		}
+	}
+	return r.defaultStack
+}
+
+func (r RouteRule) matches(e *mail.Envelope) bool {
+	actual := extractRouteField(e, r.Field)
+	switch r.Operator {
+	case "eq":
+		return strings.EqualFold(actual, r.Value)
+	case "contains":
+		return strings.Contains(strings.ToLower(actual), strings.ToLower(r.Value))
+	case "gt", "lt":
+		actualN, err1 := strconv.Atoi(actual)
+		valueN, err2 := strconv.Atoi(r.Value)
+		if err1 != nil || err2 != nil {
+			return false
+		}
+		if r.Operator == "gt" {
+			return actualN > valueN
+		}
+		return actualN < valueN
+	default:
+		return false
+	}
+}
+
+// extractRouteField pulls the comparable string value of field off e.
+func extractRouteField(e *mail.Envelope, field string) string {
+	switch {
+	case field == "rcpt_domain":
+		if len(e.RcptTo) == 0 {
+			return ""
+		}
+		return e.RcptTo[len(e.RcptTo)-1].Host
+	case field == "tls":
+		if e.TLS {
+			return "true"
+		}
+		return "false"
+	case field == "size":
+		return strconv.Itoa(e.Data.Len())
+	case strings.HasPrefix(field, "header:"):
+		return e.Header.Get(strings.TrimPrefix(field, "header:"))
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,155 @@
+package backends
+
+import (
+	"bytes"
+	"net/textproto"
+	"testing"
+
+	"github.com/flashmob/go-guerrilla/mail"
+)
+
+func envelopeWithSize(n int) *mail.Envelope {
+	e := &mail.Envelope{Header: textproto.MIMEHeader{}}
+	e.Data.Write(bytes.Repeat([]byte("a"), n))
+	return e
+}
+
+func TestRouteRuleMatches(t *testing.T) {
+	cases := []struct {
+		name string
+		rule RouteRule
+		e    *mail.Envelope
+		want bool
+	}{
+		{
+			name: "rcpt_domain eq match",
+			rule: RouteRule{Field: "rcpt_domain", Operator: "eq", Value: "example.com"},
+			e:    &mail.Envelope{RcptTo: []mail.Address{{User: "a", Host: "example.com"}}},
+			want: true,
+		},
+		{
+			name: "rcpt_domain eq is case-insensitive",
+			rule: RouteRule{Field: "rcpt_domain", Operator: "eq", Value: "EXAMPLE.com"},
+			e:    &mail.Envelope{RcptTo: []mail.Address{{User: "a", Host: "example.com"}}},
+			want: true,
+		},
+		{
+			name: "rcpt_domain eq uses the last recipient",
+			rule: RouteRule{Field: "rcpt_domain", Operator: "eq", Value: "second.com"},
+			e: &mail.Envelope{RcptTo: []mail.Address{
+				{User: "a", Host: "first.com"},
+				{User: "b", Host: "second.com"},
+			}},
+			want: true,
+		},
+		{
+			name: "rcpt_domain eq no recipients never matches",
+			rule: RouteRule{Field: "rcpt_domain", Operator: "eq", Value: "example.com"},
+			e:    &mail.Envelope{},
+			want: false,
+		},
+		{
+			name: "tls eq true",
+			rule: RouteRule{Field: "tls", Operator: "eq", Value: "true"},
+			e:    &mail.Envelope{TLS: true},
+			want: true,
+		},
+		{
+			name: "tls eq false by default",
+			rule: RouteRule{Field: "tls", Operator: "eq", Value: "true"},
+			e:    &mail.Envelope{},
+			want: false,
+		},
+		{
+			name: "header contains",
+			rule: RouteRule{Field: "header:X-Spam", Operator: "contains", Value: "yes"},
+			e: &mail.Envelope{Header: textproto.MIMEHeader{
+				"X-Spam": []string{"result: YES"},
+			}},
+			want: true,
+		},
+		{
+			name: "size gt",
+			rule: RouteRule{Field: "size", Operator: "gt", Value: "10"},
+			e:    envelopeWithSize(20),
+			want: true,
+		},
+		{
+			name: "size gt false when smaller",
+			rule: RouteRule{Field: "size", Operator: "gt", Value: "10"},
+			e:    envelopeWithSize(5),
+			want: false,
+		},
+		{
+			name: "size lt",
+			rule: RouteRule{Field: "size", Operator: "lt", Value: "10"},
+			e:    envelopeWithSize(5),
+			want: true,
+		},
+		{
+			name: "unknown operator never matches",
+			rule: RouteRule{Field: "rcpt_domain", Operator: "regex", Value: "example.com"},
+			e:    &mail.Envelope{RcptTo: []mail.Address{{User: "a", Host: "example.com"}}},
+			want: false,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := c.rule.matches(c.e); got != c.want {
+				t.Fatalf("matches() = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestRuleRouterRoute(t *testing.T) {
+	r, err := newRuleRouter([]RouteRule{
+		{Field: "rcpt_domain", Operator: "eq", Value: "relay.example.com", Stack: "relay"},
+		{Field: "size", Operator: "gt", Value: "1000", Stack: "quarantine"},
+	}, "default")
+	if err != nil {
+		t.Fatalf("newRuleRouter() error = %v", err)
+	}
+
+	relay := &mail.Envelope{RcptTo: []mail.Address{{User: "a", Host: "relay.example.com"}}}
+	if got := r.Route(relay); got != "relay" {
+		t.Fatalf("Route() = %q, want %q", got, "relay")
+	}
+
+	big := envelopeWithSize(2000)
+	if got := r.Route(big); got != "quarantine" {
+		t.Fatalf("Route() = %q, want %q", got, "quarantine")
+	}
+
+	plain := &mail.Envelope{RcptTo: []mail.Address{{User: "a", Host: "elsewhere.com"}}}
+	if got := r.Route(plain); got != "default" {
+		t.Fatalf("Route() = %q, want %q", got, "default")
+	}
+}
+
+func TestNewRuleRouterRejectsUnknownField(t *testing.T) {
+	_, err := newRuleRouter([]RouteRule{
+		{Field: "bogus", Operator: "eq", Value: "x", Stack: "default"},
+	}, "default")
+	if err == nil {
+		t.Fatal("expected an error for an unknown route field, got nil")
+	}
+}
+
+func TestNewRuleRouterRejectsUnknownOperator(t *testing.T) {
+	_, err := newRuleRouter([]RouteRule{
+		{Field: "size", Operator: "regex", Value: "x", Stack: "default"},
+	}, "default")
+	if err == nil {
+		t.Fatal("expected an error for an unknown route operator, got nil")
+	}
+}
+
+func TestNewRuleRouterRejectsMissingStack(t *testing.T) {
+	_, err := newRuleRouter([]RouteRule{
+		{Field: "size", Operator: "gt", Value: "1"},
+	}, "default")
+	if err == nil {
+		t.Fatal("expected an error for a rule with no target stack, got nil")
+	}
+}
@@ -0,0 +1,138 @@
+package backends
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// SelectTask tells a worker which operation to perform on the envelope
+// placed on the conveyor.
+type SelectTask int
+
+const (
+	// TaskSaveMail asks a worker to run the envelope through the selected
+	// processor stack and persist it
+	TaskSaveMail SelectTask = iota
+	// TaskValidateRcpt asks a worker to validate the last recipient
+	// appended to the envelope's RcptTo
+	TaskValidateRcpt
+	// TaskHealthCheck asks a worker to answer a liveness ping without
+	// running the envelope through a processor stack at all - a response
+	// is proof the dispatch loop itself is still alive and keeping up
+	// with the conveyor
+	TaskHealthCheck
+)
+
+// notifyMsg is sent back on workerMsg.notifyMe once a worker has finished
+// (or given up on) a task.
+type notifyMsg struct {
+	err      error
+	queuedID string
+}
+
+// FatalProcessorError is what a decorator returns to mean "the worker itself
+// is broken" (eg. it lost its DB handle) rather than "this one envelope
+// failed." dispatch still reports Err back to the caller like any other
+// failure, but also bubbles the wrapper up through workDispatcher so
+// superviseWorker restarts the worker's dispatch loop. The restarted loop
+// reuses the worker's existing processor stacks; the decorators are not
+// rebuilt.
+type FatalProcessorError struct {
+	Err error
+}
+
+func (e FatalProcessorError) Error() string { return e.Err.Error() }
+func (e FatalProcessorError) Unwrap() error { return e.Err }
+
+// Worker dispatches workerMsg values taken off the conveyor to the
+// processor stack selected for each envelope by a Router.
+type Worker struct{}
+
+// workDispatcher is the main loop of a single save-mail worker. It reads off
+// conveyor until the channel is closed (graceful Shutdown, after the
+// conveyor drains), ctx is done (Shutdown cancelling the gateway context
+// because a decorator is wedged), or a decorator reports itself broken via
+// FatalProcessorError, dispatching each workerMsg to the stack named by
+// router.Route(msg.e), falling back to "default", and reporting progress on
+// status so Stats()/Dump() reflect live state.
+//
+// Returns nil when conveyor closes normally; returns ctx.Err() when ctx is
+// done first, and the FatalProcessorError itself when a decorator reports
+// itself broken - both distinguishable by superviseWorker so it only
+// restarts a worker on the latter.
+func (w *Worker) workDispatcher(ctx context.Context, conveyor chan *workerMsg, stacks map[string]Processor, router Router, status *WorkerStatus) error {
+	for {
+		select {
+		case msg, ok := <-conveyor:
+			if !ok {
+				return nil
+			}
+			if err := w.dispatch(stacks, router, status, msg); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// dispatch runs a single workerMsg through the stack selected for it (or
+// answers it directly, for TaskHealthCheck), notifies the caller, and
+// returns a non-nil error only when the decorator reported itself broken via
+// FatalProcessorError - that's the only case workDispatcher should return on.
+func (w *Worker) dispatch(stacks map[string]Processor, router Router, status *WorkerStatus, msg *workerMsg) error {
+	if msg.task == TaskHealthCheck {
+		msg.notifyMe <- &notifyMsg{}
+		return nil
+	}
+
+	ctx := msg.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	// give decorators a way to observe the same cancellation ProcessCtx/
+	// ValidateRcptCtx react to, without changing the Processor interface
+	msg.e.Ctx = ctx
+
+	status.begin(msg.e.QueuedId)
+
+	stackName := "default"
+	if router != nil {
+		if name := router.Route(msg.e); name != "" {
+			stackName = name
+		}
+	}
+	p, ok := stacks[stackName]
+	if !ok {
+		p, ok = stacks["default"]
+	}
+
+	var err error
+	if ok {
+		_, err = p.Process(msg.e, msg.task)
+	} else {
+		err = fmt.Errorf("processor stack %q not found", stackName)
+	}
+	status.end(err)
+
+	var fatal FatalProcessorError
+	isFatal := errors.As(err, &fatal)
+	reportErr := err
+	if isFatal {
+		reportErr = fatal.Err
+	}
+
+	// the caller may already have given up (ProcessCtx/ValidateRcptCtx
+	// returned on ctx.Done()) and stopped reading notifyMe - don't block
+	// this worker forever trying to report a result nobody wants anymore
+	select {
+	case msg.notifyMe <- &notifyMsg{err: reportErr, queuedID: msg.e.QueuedId}:
+	case <-ctx.Done():
+	}
+
+	if isFatal {
+		return fatal
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package backends
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/flashmob/go-guerrilla/mail"
+)
+
+// WorkerStatus is a live snapshot of what a single save-mail worker is doing.
+// It's updated by workDispatcher before/after invoking the processor stack,
+// and by the tracing decorator wrapped around each named decorator in the
+// stack, so an operator can tell not just that a worker is busy but which
+// decorator it is currently stuck in.
+type WorkerStatus struct {
+	// WorkerID is the 1-indexed id Initialize assigns the worker, the same
+	// id superviseWorker uses in its log messages
+	WorkerID int
+
+	mu sync.RWMutex
+	// QueueID is the Message-ID/queue id of the envelope currently being
+	// processed, empty when the worker is idle
+	queueID string
+	// activeDecorator is the name of the Decorator currently executing,
+	// as recorded by the tracingProcessor that traceDecorator wraps it in
+	activeDecorator string
+	// stageStarted is when activeDecorator started executing
+	stageStarted time.Time
+
+	// processed and errors are monotonic counters, safe to read without mu
+	processed uint64
+	errors    uint64
+
+	lastErr   error
+	lastErrAt time.Time
+}
+
+// newWorkerStatus creates an idle WorkerStatus for the given worker id.
+func newWorkerStatus(workerID int) *WorkerStatus {
+	return &WorkerStatus{WorkerID: workerID}
+}
+
+// begin marks the worker as having picked up a new envelope off the conveyor.
+func (s *WorkerStatus) begin(queueID string) {
+	s.mu.Lock()
+	s.queueID = queueID
+	s.activeDecorator = ""
+	s.stageStarted = time.Now()
+	s.mu.Unlock()
+}
+
+// enter marks name as the currently-executing decorator, called by the
+// tracing decorator right before it invokes the next Processor in the stack.
+func (s *WorkerStatus) enter(name string) {
+	s.mu.Lock()
+	s.activeDecorator = name
+	s.stageStarted = time.Now()
+	s.mu.Unlock()
+}
+
+// end marks the current task as finished, bumping the counters and clearing
+// the active decorator so the worker reports as idle again.
+func (s *WorkerStatus) end(err error) {
+	atomic.AddUint64(&s.processed, 1)
+	if err != nil {
+		atomic.AddUint64(&s.errors, 1)
+	}
+	s.mu.Lock()
+	s.queueID = ""
+	s.activeDecorator = ""
+	if err != nil {
+		s.lastErr = err
+		s.lastErrAt = time.Now()
+	}
+	s.mu.Unlock()
+}
+
+// Snapshot returns a point-in-time copy of the status, safe to read
+// concurrently with the worker updating it.
+func (s *WorkerStatus) Snapshot() WorkerStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return WorkerStatus{
+		WorkerID:        s.WorkerID,
+		queueID:         s.queueID,
+		activeDecorator: s.activeDecorator,
+		stageStarted:    s.stageStarted,
+		processed:       atomic.LoadUint64(&s.processed),
+		errors:          atomic.LoadUint64(&s.errors),
+		lastErr:         s.lastErr,
+		lastErrAt:       s.lastErrAt,
+	}
+}
+
+// QueueID returns the queue id / Message-ID being processed, or "" if idle.
+func (s WorkerStatus) QueueID() string { return s.queueID }
+
+// ActiveDecorator returns the name of the decorator currently executing, or
+// "" if the worker is idle.
+func (s WorkerStatus) ActiveDecorator() string { return s.activeDecorator }
+
+// Elapsed returns how long the worker has been in its current stage.
+func (s WorkerStatus) Elapsed() time.Duration {
+	if s.stageStarted.IsZero() {
+		return 0
+	}
+	return time.Since(s.stageStarted)
+}
+
+// Processed returns the total number of tasks this worker has completed.
+func (s WorkerStatus) Processed() uint64 { return s.processed }
+
+// Errors returns the total number of tasks this worker has finished with an error.
+func (s WorkerStatus) Errors() uint64 { return s.errors }
+
+// LastError returns the most recent error this worker encountered, if any.
+func (s WorkerStatus) LastError() error { return s.lastErr }
+
+func (s WorkerStatus) String() string {
+	if s.activeDecorator == "" {
+		return fmt.Sprintf("worker %d: idle (processed=%d errors=%d)", s.WorkerID, s.processed, s.errors)
+	}
+	return fmt.Sprintf("worker %d: %s[%s] running %s (processed=%d errors=%d)",
+		s.WorkerID, s.activeDecorator, s.queueID, s.Elapsed(), s.processed, s.errors)
+}
+
+// tracingProcessor wraps a Processor so that status.enter(name) is called
+// immediately before the wrapped Processor runs, letting Stats()/Dump()
+// report which decorator in the stack a worker is currently inside.
+type tracingProcessor struct {
+	name   string
+	status *WorkerStatus
+	next   Processor
+}
+
+func (t tracingProcessor) Process(e *mail.Envelope, task SelectTask) (Result, error) {
+	t.status.enter(t.name)
+	return t.next.Process(e, task)
+}
+
+// Stats returns a snapshot of every worker's current status.
+func (gw *BackendGateway) Stats() []WorkerStatus {
+	gw.Lock()
+	statuses := gw.statuses
+	gw.Unlock()
+	out := make([]WorkerStatus, len(statuses))
+	for i, s := range statuses {
+		out[i] = s.Snapshot()
+	}
+	return out
+}
+
+// Dump writes a diagnostic report to w: each worker's current status,
+// followed by the stack trace of every running goroutine (runtime.Stack
+// only supports dumping all goroutines at once, so a wedged worker's stack
+// is in there along with everything else).
+func (gw *BackendGateway) Dump(w io.Writer) {
+	for _, s := range gw.Stats() {
+		fmt.Fprintln(w, s.String())
+	}
+	buf := make([]byte, 1<<20)
+	n := runtime.Stack(buf, true)
+	fmt.Fprintln(w, "--- goroutine dump ---")
+	w.Write(buf[:n])
+}
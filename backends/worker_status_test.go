@@ -0,0 +1,50 @@
+package backends
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestWorkerStatusBeginEnd(t *testing.T) {
+	s := newWorkerStatus(1)
+
+	idle := s.Snapshot()
+	if idle.QueueID() != "" || idle.ActiveDecorator() != "" || idle.Processed() != 0 || idle.Errors() != 0 {
+		t.Fatalf("expected a fresh WorkerStatus to be idle, got %+v", idle)
+	}
+
+	s.begin("queue-1")
+	s.enter("Hasher")
+	busy := s.Snapshot()
+	if busy.QueueID() != "queue-1" {
+		t.Fatalf("QueueID() = %q, want %q", busy.QueueID(), "queue-1")
+	}
+	if busy.ActiveDecorator() != "Hasher" {
+		t.Fatalf("ActiveDecorator() = %q, want %q", busy.ActiveDecorator(), "Hasher")
+	}
+
+	s.end(nil)
+	afterOK := s.Snapshot()
+	if afterOK.QueueID() != "" || afterOK.ActiveDecorator() != "" {
+		t.Fatalf("expected worker to report idle after end(nil), got %+v", afterOK)
+	}
+	if afterOK.Processed() != 1 {
+		t.Fatalf("Processed() = %d, want 1", afterOK.Processed())
+	}
+	if afterOK.Errors() != 0 {
+		t.Fatalf("Errors() = %d, want 0", afterOK.Errors())
+	}
+
+	s.begin("queue-2")
+	s.end(errors.New("boom"))
+	afterErr := s.Snapshot()
+	if afterErr.Processed() != 2 {
+		t.Fatalf("Processed() = %d, want 2", afterErr.Processed())
+	}
+	if afterErr.Errors() != 1 {
+		t.Fatalf("Errors() = %d, want 1", afterErr.Errors())
+	}
+	if afterErr.LastError() == nil || afterErr.LastError().Error() != "boom" {
+		t.Fatalf("LastError() = %v, want %q", afterErr.LastError(), "boom")
+	}
+}
@@ -0,0 +1,32 @@
+package backends
+
+import (
+	"testing"
+
+	"github.com/flashmob/go-guerrilla/mail"
+)
+
+func TestDispatchHealthCheckSkipsProcessorStack(t *testing.T) {
+	w := &Worker{}
+	status := newWorkerStatus(1)
+	notify := make(chan *notifyMsg, 1)
+	msg := &workerMsg{e: &mail.Envelope{}, notifyMe: notify, task: TaskHealthCheck}
+
+	// with nil stacks/router a normal save would report a "processor stack
+	// not found" error and count as processed - TaskHealthCheck must
+	// short-circuit before routing happens
+	w.dispatch(nil, nil, status, msg)
+
+	select {
+	case resp := <-notify:
+		if resp.err != nil {
+			t.Fatalf("health check notifyMsg.err = %v, want nil", resp.err)
+		}
+	default:
+		t.Fatal("dispatch did not notify the health check caller")
+	}
+
+	if got := status.Snapshot(); got.Processed() != 0 {
+		t.Fatalf("health check should not count as processed, got Processed() = %d", got.Processed())
+	}
+}
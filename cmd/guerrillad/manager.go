@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/flashmob/go-guerrilla/backends"
+	"github.com/spf13/cobra"
+)
+
+// managerSocket is the default path of the Unix socket the running daemon
+// listens on for manager commands. Overridable with --socket.
+const managerSocket = "/var/run/guerrillad.sock"
+
+var managerSocketPath string
+
+// managerCmd groups the operational subcommands that talk to an already
+// running guerrillad over managerSocket instead of needing a process
+// restart: flush, drain, reload-backend and status.
+var managerCmd = &cobra.Command{
+	Use:   "manager",
+	Short: "Control a running guerrillad without restarting it",
+}
+
+var managerFlushTimeout time.Duration
+var managerFlushNonBlocking bool
+
+var managerFlushCmd = &cobra.Command{
+	Use:   "flush",
+	Short: "Stop accepting new mail and wait for the backend queue to empty",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return callManager(managerRequest{Action: "flush", Timeout: managerFlushTimeout, NonBlocking: managerFlushNonBlocking})
+	},
+}
+
+var managerDrainCmd = &cobra.Command{
+	Use:   "drain",
+	Short: "Flush the backend queue, then shut it down",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return callManager(managerRequest{Action: "drain", Timeout: managerFlushTimeout, NonBlocking: managerFlushNonBlocking})
+	},
+}
+
+var managerReloadBackendCmd = &cobra.Command{
+	Use:   "reload-backend",
+	Short: "Re-read the config file and reinitialize the backend gateway",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return callManager(managerRequest{Action: "reload-backend"})
+	},
+}
+
+var managerStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Print per-worker state from the running daemon",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return callManager(managerRequest{Action: "status"})
+	},
+}
+
+func init() {
+	managerCmd.PersistentFlags().StringVar(&managerSocketPath, "socket", managerSocket, "path to the guerrillad manager socket")
+	managerFlushCmd.Flags().DurationVar(&managerFlushTimeout, "timeout", 60*time.Second, "how long to wait for the queue to empty")
+	managerFlushCmd.Flags().BoolVar(&managerFlushNonBlocking, "non-blocking", false, "return immediately instead of waiting for the queue to empty")
+	managerDrainCmd.Flags().DurationVar(&managerFlushTimeout, "timeout", 60*time.Second, "how long to wait for the queue to empty")
+	managerDrainCmd.Flags().BoolVar(&managerFlushNonBlocking, "non-blocking", false, "return immediately instead of waiting for the queue to empty")
+	managerCmd.AddCommand(managerFlushCmd, managerDrainCmd, managerReloadBackendCmd, managerStatusCmd)
+	rootCmd.AddCommand(managerCmd)
+}
+
+// managerRequest is the JSON payload sent over managerSocket.
+type managerRequest struct {
+	Action      string        `json:"action"`
+	Timeout     time.Duration `json:"timeout,omitempty"`
+	NonBlocking bool          `json:"non_blocking,omitempty"`
+}
+
+// managerResponse is the JSON payload read back from managerSocket.
+type managerResponse struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+	// Workers holds one WorkerStatus.String() line per worker; the fields of
+	// backends.WorkerStatus are unexported and would not survive JSON
+	Workers []string `json:"workers,omitempty"`
+}
+
+// callManager dials managerSocketPath, sends req and prints the response.
+func callManager(req managerRequest) error {
+	conn, err := net.DialTimeout("unix", managerSocketPath, 5*time.Second)
+	if err != nil {
+		return fmt.Errorf("could not reach guerrillad manager socket %s: %s", managerSocketPath, err)
+	}
+	defer conn.Close()
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return fmt.Errorf("error sending manager request: %s", err)
+	}
+	var resp managerResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return fmt.Errorf("error reading manager response: %s", err)
+	}
+	if !resp.OK {
+		return fmt.Errorf("%s", resp.Error)
+	}
+	for _, w := range resp.Workers {
+		fmt.Fprintln(os.Stdout, w)
+	}
+	return nil
+}
+
+// managerServer is the daemon side of the manager socket. It's registered by
+// serve.go at startup and wired to a live *backends.BackendGateway so that
+// `guerrillad manager` subcommands can drive the gateway without a restart.
+type managerServer struct {
+	gw *backends.BackendGateway
+	// reload is called for the "reload-backend" action; serve.go supplies
+	// a closure that re-reads the config file and calls gw.Shutdown +
+	// gw.Initialize with the fresh config
+	reload func() error
+}
+
+// ListenAndServeManager listens on socket and serves manager requests until
+// the context is cancelled. It removes any stale socket file left behind by
+// a previous unclean shutdown before binding.
+func ListenAndServeManager(ctx context.Context, socket string, gw *backends.BackendGateway, reload func() error) error {
+	_ = os.Remove(socket)
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		return fmt.Errorf("could not listen on manager socket %s: %s", socket, err)
+	}
+	srv := &managerServer{gw: gw, reload: reload}
+	go func() {
+		<-ctx.Done()
+		l.Close()
+		os.Remove(socket)
+	}()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				return nil
+			default:
+				return err
+			}
+		}
+		go srv.handle(conn)
+	}
+}
+
+func (s *managerServer) handle(conn net.Conn) {
+	defer conn.Close()
+	var req managerRequest
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		return
+	}
+	resp := s.dispatch(req)
+	json.NewEncoder(conn).Encode(resp)
+}
+
+func (s *managerServer) dispatch(req managerRequest) managerResponse {
+	switch req.Action {
+	case "flush", "drain":
+		ctx := context.Background()
+		if !req.NonBlocking {
+			var cancel context.CancelFunc
+			timeout := req.Timeout
+			if timeout <= 0 {
+				timeout = 60 * time.Second
+			}
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+			if err := s.gw.Flush(ctx); err != nil {
+				return managerResponse{OK: false, Error: fmt.Sprintf("flush did not complete: %s", err)}
+			}
+		} else {
+			go s.gw.Flush(context.Background())
+		}
+		if req.Action == "drain" {
+			if err := s.gw.Shutdown(); err != nil {
+				return managerResponse{OK: false, Error: err.Error()}
+			}
+		}
+		return managerResponse{OK: true}
+	case "reload-backend":
+		if s.reload == nil {
+			return managerResponse{OK: false, Error: "reload is not supported by this daemon"}
+		}
+		if err := s.reload(); err != nil {
+			return managerResponse{OK: false, Error: err.Error()}
+		}
+		return managerResponse{OK: true}
+	case "status":
+		stats := s.gw.Stats()
+		workers := make([]string, len(stats))
+		for i, w := range stats {
+			workers[i] = w.String()
+		}
+		return managerResponse{OK: true, Workers: workers}
+	default:
+		return managerResponse{OK: false, Error: fmt.Sprintf("unknown action %q", req.Action)}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/flashmob/go-guerrilla/backends"
+	"github.com/spf13/cobra"
+)
+
+// rootCmd is the guerrillad entry point. manager.go, serve.go and any other
+// subcommand file register themselves onto it from their own init().
+var rootCmd = &cobra.Command{
+	Use:   "guerrillad",
+	Short: "guerrillad is the daemon for go-guerrilla mail server",
+}
+
+// gw is the backend gateway the running daemon drives. It's what the
+// manager socket (see manager.go) is wired to, so `guerrillad manager`
+// subcommands act on the same gateway instance serving live mail.
+var gw = &backends.BackendGateway{}
+
+var serveConfigPath string
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Start the guerrillad daemon",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return serve(serveConfigPath)
+	},
+}
+
+func init() {
+	serveCmd.Flags().StringVar(&serveConfigPath, "config", "goguerrilla.conf.json", "path to the configuration file")
+	rootCmd.AddCommand(serveCmd)
+}
+
+// readBackendConfig reads and parses the backend section of the config file
+// at path into a backends.BackendConfig.
+func readBackendConfig(path string) (backends.BackendConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config %s: %s", path, err)
+	}
+	var cfg backends.BackendConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("error parsing config %s: %s", path, err)
+	}
+	return cfg, nil
+}
+
+// serve initializes the backend gateway from configPath, starts the manager
+// socket alongside it so `guerrillad manager` subcommands can drive gw
+// without a restart, and blocks until the manager listener stops.
+func serve(configPath string) error {
+	cfg, err := readBackendConfig(configPath)
+	if err != nil {
+		return err
+	}
+	if err := gw.Initialize(cfg); err != nil {
+		return fmt.Errorf("error initializing backend: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reload := func() error {
+		return reloadBackend(configPath)
+	}
+
+	return ListenAndServeManager(ctx, managerSocketPath, gw, reload)
+}
+
+// reloadBackend re-reads configPath and swaps it into gw without a process
+// restart: it shuts the gateway down, then re-Initializes it with the fresh
+// config, which leaves gw back in BackendStateRunning on success. Split out
+// of serve's reload closure so `guerrillad manager reload-backend` can be
+// exercised directly in tests.
+func reloadBackend(configPath string) error {
+	newCfg, err := readBackendConfig(configPath)
+	if err != nil {
+		return err
+	}
+	if err := gw.Shutdown(); err != nil {
+		return err
+	}
+	return gw.Initialize(newCfg)
+}
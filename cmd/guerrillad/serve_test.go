@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/flashmob/go-guerrilla/backends"
+)
+
+func TestReloadBackendLeavesGatewayRunning(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "goguerrilla.conf.json")
+	if err := os.WriteFile(configPath, []byte(`{"save_workers_size": 1}`), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	cfg, err := readBackendConfig(configPath)
+	if err != nil {
+		t.Fatalf("readBackendConfig() error = %v", err)
+	}
+	if err := gw.Initialize(cfg); err != nil {
+		t.Fatalf("gw.Initialize() error = %v", err)
+	}
+	defer gw.Shutdown()
+
+	if err := reloadBackend(configPath); err != nil {
+		t.Fatalf("reloadBackend() error = %v", err)
+	}
+
+	if gw.State != backends.BackendStateRunning {
+		t.Fatalf("gw.State = %v after reloadBackend, want BackendStateRunning", gw.State)
+	}
+}